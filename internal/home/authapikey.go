@@ -0,0 +1,249 @@
+package home
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghuser"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/netutil"
+)
+
+// apiKeyPrefix is prepended to every issued token so that API keys are
+// visually distinguishable from session cookies in logs and configs.
+const apiKeyPrefix = "agh_"
+
+// apiKeySecretLength is the number of random bytes used for the secret part
+// of an API key, before the apiKeyPrefix.
+const apiKeySecretLength = 32
+
+// newAPIKeyJSON is the request body for POST /control/users/{login}/apikeys.
+type newAPIKeyJSON struct {
+	Name      string     `json:"name"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// apiKeyJSON is the JSON representation of an API key, as returned by the
+// GET /control/users/{login}/apikeys HTTP API.  It never contains the
+// plaintext secret.
+//
+// TODO(s.chzhen):  Add a Scopes field once [aghuser.APIKey] scopes are
+// actually enforced in userFromRequestAPIKey; until then, accepting them
+// on creation would grant full account access while implying a
+// restriction that isn't there.
+type apiKeyJSON struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+// createdAPIKeyJSON is the response to POST /control/users/{login}/apikeys.
+// Token is only ever returned once, at creation time.
+type createdAPIKeyJSON struct {
+	apiKeyJSON
+	Token string `json:"token"`
+}
+
+// generateAPIKey returns a new plaintext API key and the SHA-256 hash that
+// should be persisted in place of it.
+func generateAPIKey() (token string, hash []byte, err error) {
+	secret := make([]byte, apiKeySecretLength)
+	if _, err = rand.Read(secret); err != nil {
+		return "", nil, fmt.Errorf("reading random bytes: %w", err)
+	}
+
+	token = apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	sum := sha256.Sum256([]byte(token))
+
+	return token, sum[:], nil
+}
+
+// handleCreateAPIKey is the handler for the
+// POST /control/users/{login}/apikeys HTTP API.
+func handleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	login := aghuser.Login(r.PathValue("login"))
+
+	u := webUserFromContext(ctx)
+	if u == nil || u.Login != login {
+		aghhttp.Error(r, w, http.StatusForbidden, "can only manage your own api keys")
+
+		return
+	}
+
+	req := newAPIKeyJSON{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "json decode: %s", err)
+
+		return
+	}
+
+	token, hash, err := generateAPIKey()
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "generating api key: %s", err)
+
+		return
+	}
+
+	rec := &aghuser.APIKey{
+		UserLogin: login,
+		Name:      req.Name,
+		Hash:      hash,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: req.ExpiresAt,
+	}
+
+	err = globalContext.auth.apiKeys.Create(ctx, rec)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "creating api key: %s", err)
+
+		return
+	}
+
+	resp := createdAPIKeyJSON{
+		apiKeyJSON: apiKeyJSON{
+			ID:        rec.ID,
+			Name:      rec.Name,
+			CreatedAt: rec.CreatedAt,
+			ExpiresAt: rec.ExpiresAt,
+		},
+		Token: token,
+	}
+
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// handleListAPIKeys is the handler for the GET /control/users/{login}/apikeys
+// HTTP API.
+func handleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	login := aghuser.Login(r.PathValue("login"))
+
+	u := webUserFromContext(ctx)
+	if u == nil || u.Login != login {
+		aghhttp.Error(r, w, http.StatusForbidden, "can only manage your own api keys")
+
+		return
+	}
+
+	keys, err := globalContext.auth.apiKeys.ByUser(ctx, login)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "listing api keys: %s", err)
+
+		return
+	}
+
+	resp := make([]apiKeyJSON, 0, len(keys))
+	for _, k := range keys {
+		resp = append(resp, apiKeyJSON{
+			ID:         k.ID,
+			Name:       k.Name,
+			CreatedAt:  k.CreatedAt,
+			LastUsedAt: k.LastUsedAt,
+			ExpiresAt:  k.ExpiresAt,
+		})
+	}
+
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// handleDeleteAPIKey is the handler for the
+// DELETE /control/users/{login}/apikeys/{id} HTTP API.
+func handleDeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	login := aghuser.Login(r.PathValue("login"))
+	id := r.PathValue("id")
+
+	u := webUserFromContext(ctx)
+	if u == nil || u.Login != login {
+		aghhttp.Error(r, w, http.StatusForbidden, "can only manage your own api keys")
+
+		return
+	}
+
+	err := globalContext.auth.apiKeys.Delete(ctx, login, id)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "deleting api key: %s", err)
+
+		return
+	}
+
+	aghhttp.OK(w)
+}
+
+// registerAPIKeyHandlers registers the API key management routes.
+func registerAPIKeyHandlers() {
+	httpRegister(http.MethodPost, "/control/users/{login}/apikeys", handleCreateAPIKey)
+	httpRegister(http.MethodGet, "/control/users/{login}/apikeys", handleListAPIKeys)
+	httpRegister(http.MethodDelete, "/control/users/{login}/apikeys/{id}", handleDeleteAPIKey)
+}
+
+// tokenFromAPIKeyHeaders extracts a bearer-style API key from the standard
+// Authorization header or the X-API-Key header, in that order.
+func tokenFromAPIKeyHeaders(r *http.Request) (token string, ok bool) {
+	if auth := r.Header.Get(httphdr.Authorization); auth != "" {
+		if rest, found := strings.CutPrefix(auth, "Bearer "); found {
+			return rest, true
+		}
+	}
+
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key, true
+	}
+
+	return "", false
+}
+
+// userFromRequestAPIKey searches for a user using a bearer API key.  A valid
+// key bypasses the login rate limiter entirely; an unknown or expired one
+// counts against it.
+func (mw *authMiddlewareDefault) userFromRequestAPIKey(
+	ctx context.Context,
+	r *http.Request,
+) (u *aghuser.User, err error) {
+	token, ok := tokenFromAPIKeyHeaders(r)
+	if !ok {
+		return nil, nil
+	}
+
+	sum := sha256.Sum256([]byte(token))
+
+	rec, err := globalContext.auth.apiKeys.ByHash(ctx, sum[:])
+	if err != nil {
+		return nil, fmt.Errorf("looking up api key: %w", err)
+	}
+
+	if rec == nil ||
+		subtle.ConstantTimeCompare(rec.Hash, sum[:]) != 1 ||
+		(rec.ExpiresAt != nil && time.Now().After(*rec.ExpiresAt)) {
+		if rateLimiter := globalContext.auth.rateLimiter; rateLimiter != nil {
+			if remoteIP, ipErr := netutil.SplitHost(r.RemoteAddr); ipErr == nil {
+				rateLimiter.inc(remoteIP)
+			}
+		}
+
+		return nil, errInvalidLogin
+	}
+
+	u, err = mw.users.ByLogin(ctx, rec.UserLogin)
+	if err != nil {
+		return nil, fmt.Errorf("searching user by login %q: %w", rec.UserLogin, err)
+	}
+
+	go func() {
+		_ = globalContext.auth.apiKeys.TouchLastUsed(context.Background(), rec.ID)
+	}()
+
+	return u, nil
+}
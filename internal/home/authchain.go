@@ -0,0 +1,172 @@
+package home
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghuser"
+)
+
+// AuthMethod identifies which [AuthProvider] authenticated a request.  It is
+// threaded through to callers, such as [checkCSRF], that need to distinguish
+// ambient cookie authentication from credentials the caller sends explicitly
+// on every request, rather than re-deriving it by re-inspecting headers that
+// may be stale or incidental.
+type AuthMethod string
+
+// Authentication methods recognized by [AuthChain].
+const (
+	AuthMethodNone      AuthMethod = ""
+	AuthMethodAPIKey    AuthMethod = "api_key"
+	AuthMethodCookie    AuthMethod = "cookie"
+	AuthMethodBasicAuth AuthMethod = "basic_auth"
+	AuthMethodGLiNet    AuthMethod = "gl_inet"
+)
+
+// AuthProvider authenticates an HTTP request using one particular scheme,
+// e.g. a session cookie, Basic Auth, or a bearer API key.  Implementations
+// must be safe for concurrent use.
+type AuthProvider interface {
+	// Authenticate inspects r and returns the user it identifies.  handled is
+	// true if this provider recognized and processed the credentials it was
+	// looking for, regardless of whether authentication succeeded; it is
+	// false if r simply didn't carry this scheme's credentials, so that
+	// [AuthChain] knows to move on to the next provider instead of treating
+	// the absence of credentials as a failed attempt.  method identifies the
+	// scheme that produced u; it is only meaningful when handled is true.
+	//
+	// granted is true only for a provider that authorizes a request
+	// out-of-band, without mapping it to a local [aghuser.User] (currently
+	// only [glinetProvider]).  Callers must treat a nil u with granted=false
+	// as a rejection, even when handled is true: handled merely means this
+	// scheme's credentials were present, not that they were valid.
+	Authenticate(
+		ctx context.Context,
+		r *http.Request,
+	) (u *aghuser.User, method AuthMethod, handled bool, granted bool, err error)
+}
+
+// AuthChain authenticates a request by walking an ordered list of
+// [AuthProvider] implementations and stopping at the first one that handles
+// the request.
+type AuthChain struct {
+	providers []AuthProvider
+}
+
+// NewAuthChain returns a new *AuthChain that tries providers in order.
+func NewAuthChain(providers ...AuthProvider) (c *AuthChain) {
+	return &AuthChain{providers: providers}
+}
+
+// Authenticate implements the [AuthProvider] interface for *AuthChain.  It
+// returns the result of the first provider in the chain that reports handled
+// or a non-nil user; later providers are not tried, even if that result has a
+// nil user.  err is
+// only set if a provider recognized its credentials but rejected them; a
+// request that simply carries no recognized credentials returns a nil user
+// and a nil error.
+func (c *AuthChain) Authenticate(
+	ctx context.Context,
+	r *http.Request,
+) (u *aghuser.User, method AuthMethod, handled bool, granted bool, err error) {
+	for _, p := range c.providers {
+		u, method, handled, granted, err = p.Authenticate(ctx, r)
+		if u != nil || handled {
+			return u, method, handled, granted, err
+		}
+	}
+
+	return nil, AuthMethodNone, false, false, nil
+}
+
+// cookieProvider authenticates requests using the [sessionCookieName] session
+// cookie.
+type cookieProvider struct {
+	mw *authMiddlewareDefault
+}
+
+// type check
+var _ AuthProvider = cookieProvider{}
+
+// Authenticate implements the [AuthProvider] interface for cookieProvider.
+func (p cookieProvider) Authenticate(
+	ctx context.Context,
+	r *http.Request,
+) (u *aghuser.User, method AuthMethod, handled bool, granted bool, err error) {
+	_, err = r.Cookie(sessionCookieName)
+	if err == http.ErrNoCookie {
+		return nil, AuthMethodNone, false, false, nil
+	}
+
+	u, err = p.mw.userFromRequestCookie(ctx, r)
+
+	return u, AuthMethodCookie, true, false, err
+}
+
+// basicAuthProvider authenticates requests using HTTP Basic Auth, checked
+// against the local user database and, if configured, an LDAP directory.
+type basicAuthProvider struct {
+	mw *authMiddlewareDefault
+}
+
+// type check
+var _ AuthProvider = basicAuthProvider{}
+
+// Authenticate implements the [AuthProvider] interface for basicAuthProvider.
+func (p basicAuthProvider) Authenticate(
+	ctx context.Context,
+	r *http.Request,
+) (u *aghuser.User, method AuthMethod, handled bool, granted bool, err error) {
+	if _, _, ok := r.BasicAuth(); !ok {
+		return nil, AuthMethodNone, false, false, nil
+	}
+
+	u, err = p.mw.userFromRequestBasicAuth(ctx, r)
+
+	return u, AuthMethodBasicAuth, true, false, err
+}
+
+// apiKeyProvider authenticates requests using a bearer API key, so that
+// automation can authenticate without ever touching the session cookie path.
+type apiKeyProvider struct {
+	mw *authMiddlewareDefault
+}
+
+// type check
+var _ AuthProvider = apiKeyProvider{}
+
+// Authenticate implements the [AuthProvider] interface for apiKeyProvider.
+func (p apiKeyProvider) Authenticate(
+	ctx context.Context,
+	r *http.Request,
+) (u *aghuser.User, method AuthMethod, handled bool, granted bool, err error) {
+	if _, ok := tokenFromAPIKeyHeaders(r); !ok {
+		return nil, AuthMethodNone, false, false, nil
+	}
+
+	u, err = p.mw.userFromRequestAPIKey(ctx, r)
+
+	return u, AuthMethodAPIKey, true, false, err
+}
+
+// glinetProvider defers authentication to the gl-inet submodule, for devices
+// running the GL.iNet firmware integration.
+type glinetProvider struct{}
+
+// type check
+var _ AuthProvider = glinetProvider{}
+
+// Authenticate implements the [AuthProvider] interface for glinetProvider.
+func (glinetProvider) Authenticate(
+	_ context.Context,
+	r *http.Request,
+) (u *aghuser.User, method AuthMethod, handled bool, granted bool, err error) {
+	if !glProcessCookie(r) {
+		return nil, AuthMethodNone, false, false, nil
+	}
+
+	// The gl-inet submodule authenticates out-of-band and doesn't map to a
+	// local [aghuser.User]; granted=true tells [authMiddlewareDefault.Wrap]
+	// that access has already been authorized despite the nil user.
+	return nil, AuthMethodGLiNet, true, true, nil
+}
@@ -8,7 +8,6 @@ import (
 	"log/slog"
 	"net/http"
 	"net/netip"
-	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -38,7 +37,7 @@ type loginJSON struct {
 }
 
 // newCookie creates a new authentication cookie.
-func (a *Auth) newCookie(req loginJSON, addr string) (c *http.Cookie, err error) {
+func (a *Auth) newCookie(r *http.Request, req loginJSON, addr string) (c *http.Cookie, err error) {
 	rateLimiter := a.rateLimiter
 	u, ok := a.findUser(req.Name, req.Password)
 	if !ok {
@@ -61,6 +60,22 @@ func (a *Auth) newCookie(req loginJSON, addr string) (c *http.Cookie, err error)
 		expire:   uint32(now.Unix()) + a.sessionTTL,
 	})
 
+	tok := aghuser.SessionToken(sess)
+	ctx := r.Context()
+	err = a.sessions.Add(ctx, &aghuser.Session{
+		Token:     tok,
+		UserLogin: u.Login,
+		CreatedAt: now,
+		LastSeen:  now,
+		RemoteIP:  addr,
+		UserAgent: r.UserAgent(),
+	})
+	if err != nil {
+		log.Error("auth: recording session: %s", err)
+	} else {
+		evictOldestSessions(ctx, a.sessions, defaultMaxActiveSessionsPerUser, u.Login, tok)
+	}
+
 	return &http.Cookie{
 		Name:     sessionCookieName,
 		Value:    hex.EncodeToString(sess),
@@ -178,7 +193,7 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 		log.Error("auth: getting real ip from request with remote ip %s: %s", remoteIP, err)
 	}
 
-	cookie, err := globalContext.auth.newCookie(req, remoteIP)
+	cookie, err := globalContext.auth.newCookie(r, req, remoteIP)
 	if err != nil {
 		logIP := remoteIP
 		if globalContext.auth.trustedProxies.Contains(ip.Unmap()) {
@@ -194,6 +209,12 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 
 	http.SetCookie(w, cookie)
 
+	// Issue a fresh CSRF token alongside the session cookie so that the
+	// frontend can start sending it on state-changing requests immediately.
+	if _, csrfErr := setCSRFCookie(w, r); csrfErr != nil {
+		log.Error("auth: issuing csrf token: %s", csrfErr)
+	}
+
 	h := w.Header()
 	h.Set(httphdr.CacheControl, "no-store, no-cache, must-revalidate, proxy-revalidate")
 	h.Set(httphdr.Pragma, "no-cache")
@@ -217,6 +238,13 @@ func handleLogout(w http.ResponseWriter, r *http.Request) {
 
 	globalContext.auth.removeSession(c.Value)
 
+	if tok, hexErr := hex.DecodeString(c.Value); hexErr == nil {
+		ctx := r.Context()
+		if rmErr := globalContext.auth.sessions.Remove(ctx, aghuser.SessionToken(tok)); rmErr != nil {
+			log.Error("auth: revoking session: %s", rmErr)
+		}
+	}
+
 	c = &http.Cookie{
 		Name:    sessionCookieName,
 		Value:   "",
@@ -236,123 +264,11 @@ func handleLogout(w http.ResponseWriter, r *http.Request) {
 func RegisterAuthHandlers() {
 	globalContext.mux.Handle("/control/login", postInstallHandler(ensureHandler(http.MethodPost, handleLogin)))
 	httpRegister(http.MethodGet, "/control/logout", handleLogout)
-}
-
-// optionalAuthThird returns true if a user should authenticate first.
-func optionalAuthThird(w http.ResponseWriter, r *http.Request) (mustAuth bool) {
-	pref := fmt.Sprintf("auth: raddr %s", r.RemoteAddr)
-
-	if glProcessCookie(r) {
-		log.Debug("%s: authentication is handled by gl-inet submodule", pref)
-
-		return false
-	}
-
-	// redirect to login page if not authenticated
-	isAuthenticated := false
-	cookie, err := r.Cookie(sessionCookieName)
-	if err != nil {
-		// The only error that is returned from r.Cookie is [http.ErrNoCookie].
-		// Check Basic authentication.
-		user, pass, hasBasic := r.BasicAuth()
-		if hasBasic {
-			_, isAuthenticated = globalContext.auth.findUser(user, pass)
-			if !isAuthenticated {
-				log.Info("%s: invalid basic authorization value", pref)
-			}
-		}
-	} else {
-		res := globalContext.auth.checkSession(cookie.Value)
-		isAuthenticated = res == checkSessionOK
-		if !isAuthenticated {
-			log.Debug("%s: invalid cookie value: %q", pref, cookie)
-		}
-	}
-
-	if isAuthenticated {
-		return false
-	}
-
-	if p := r.URL.Path; p == "/" || p == "/index.html" {
-		if glProcessRedirect(w, r) {
-			log.Debug("%s: redirected to login page by gl-inet submodule", pref)
-		} else {
-			log.Debug("%s: redirected to login page", pref)
-			http.Redirect(w, r, "login.html", http.StatusFound)
-		}
-	} else {
-		log.Debug("%s: responded with forbidden to %s %s", pref, r.Method, p)
-		w.WriteHeader(http.StatusForbidden)
-		_, _ = w.Write([]byte("Forbidden"))
-	}
-
-	return true
-}
-
-// TODO(a.garipov): Use [http.Handler] consistently everywhere throughout the
-// project.
-func optionalAuth(
-	h func(http.ResponseWriter, *http.Request),
-) (wrapped func(http.ResponseWriter, *http.Request)) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		p := r.URL.Path
-		authRequired := globalContext.auth != nil && globalContext.auth.authRequired()
-		if p == "/login.html" {
-			cookie, err := r.Cookie(sessionCookieName)
-			if authRequired && err == nil {
-				// Redirect to the dashboard if already authenticated.
-				res := globalContext.auth.checkSession(cookie.Value)
-				if res == checkSessionOK {
-					http.Redirect(w, r, "", http.StatusFound)
-
-					return
-				}
-
-				log.Debug("auth: raddr %s: invalid cookie value: %q", r.RemoteAddr, cookie)
-			}
-		} else if isPublicResource(p) {
-			// Process as usual, no additional auth requirements.
-		} else if authRequired {
-			if optionalAuthThird(w, r) {
-				return
-			}
-		}
-
-		h(w, r)
-	}
-}
-
-// isPublicResource returns true if p is a path to a public resource.
-func isPublicResource(p string) (ok bool) {
-	isAsset, err := path.Match("/assets/*", p)
-	if err != nil {
-		// The only error that is returned from path.Match is
-		// [path.ErrBadPattern].  This is a programmer error.
-		panic(fmt.Errorf("bad asset pattern: %w", err))
-	}
-
-	isLogin, err := path.Match("/login.*", p)
-	if err != nil {
-		// Same as above.
-		panic(fmt.Errorf("bad login pattern: %w", err))
-	}
-
-	return isAsset || isLogin
-}
-
-// authHandler is a helper structure that implements [http.Handler].
-type authHandler struct {
-	handler http.Handler
-}
-
-// ServeHTTP implements the [http.Handler] interface for *authHandler.
-func (a *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	optionalAuth(a.handler.ServeHTTP)(w, r)
-}
 
-// optionalAuthHandler returns a authentication handler.
-func optionalAuthHandler(handler http.Handler) http.Handler {
-	return &authHandler{handler}
+	registerOIDCHandlers(globalContext.auth.oidc)
+	registerSessionHandlers()
+	registerAPIKeyHandlers()
+	registerCSRFHandlers()
 }
 
 const (
@@ -372,25 +288,76 @@ type authMiddlewareDefaultConfig struct {
 
 	// users contains web user information.  It must not be nil.
 	users aghuser.DB
+
+	// ldap is the LDAP authentication backend.  It is nil if LDAP
+	// authentication is disabled.
+	ldap *authMiddlewareLDAP
+
+	// maxActiveSessionsPerUser is the maximum number of concurrent sessions
+	// per user stored in [authMiddlewareDefault].  Zero means
+	// [defaultMaxActiveSessionsPerUser].  Session creation ([Auth.newCookie]
+	// and the OIDC callback) evicts the oldest sessions beyond
+	// [defaultMaxActiveSessionsPerUser] and does not read this value, so a
+	// non-default value has no effect on eviction.
+	maxActiveSessionsPerUser int
+
+	// maxSessionLifetime is the maximum time a session may be used since its
+	// creation, regardless of sliding expiry.  Zero means
+	// [defaultMaxSessionLifetime].
+	maxSessionLifetime time.Duration
 }
 
 // authMiddlewareDefault is the default authentication middleware.  It searches
 // for a web client using an authentication cookie or basic auth credentials and
 // passes it with the context.
 type authMiddlewareDefault struct {
-	logger   *slog.Logger
-	sessions aghuser.SessionStorage
-	users    aghuser.DB
+	logger                   *slog.Logger
+	sessions                 aghuser.SessionStorage
+	users                    aghuser.DB
+	ldap                     *authMiddlewareLDAP
+	maxActiveSessionsPerUser int
+	maxSessionLifetime       time.Duration
+	chain                    *AuthChain
 }
 
+// Default limits applied to web user sessions.  See
+// [authMiddlewareDefaultConfig.maxActiveSessionsPerUser] and
+// [authMiddlewareDefaultConfig.maxSessionLifetime].
+const (
+	defaultMaxActiveSessionsPerUser = 25
+	defaultMaxSessionLifetime       = 7 * timeutil.Day
+)
+
 // newAuthMiddlewareDefault returns the new properly initialized
 // *authMiddlewareDefault.
 func newAuthMiddlewareDefault(c *authMiddlewareDefaultConfig) (mw *authMiddlewareDefault) {
-	return &authMiddlewareDefault{
-		logger:   c.logger,
-		sessions: c.sessions,
-		users:    c.users,
+	maxSessions := c.maxActiveSessionsPerUser
+	if maxSessions == 0 {
+		maxSessions = defaultMaxActiveSessionsPerUser
+	}
+
+	lifetime := c.maxSessionLifetime
+	if lifetime == 0 {
+		lifetime = defaultMaxSessionLifetime
+	}
+
+	mw = &authMiddlewareDefault{
+		logger:                   c.logger,
+		sessions:                 c.sessions,
+		users:                    c.users,
+		ldap:                     c.ldap,
+		maxActiveSessionsPerUser: maxSessions,
+		maxSessionLifetime:       lifetime,
 	}
+
+	mw.chain = NewAuthChain(
+		apiKeyProvider{mw: mw},
+		cookieProvider{mw: mw},
+		basicAuthProvider{mw: mw},
+		glinetProvider{},
+	)
+
+	return mw
 }
 
 // type check
@@ -407,52 +374,58 @@ func (mw *authMiddlewareDefault) Wrap(h http.Handler) (wrapped http.Handler) {
 			return
 		}
 
-		u, err := mw.userFromRequest(ctx, r)
-		if u != nil {
-			h.ServeHTTP(w, r.WithContext(withWebUser(ctx, u)))
+		u, method, _, granted, err := mw.chain.Authenticate(ctx, r)
+		if err != nil {
+			mw.logger.ErrorContext(ctx, "retrieving user from request", slogutil.KeyError, err)
+		}
+
+		// A provider reporting handled=true only means this scheme's
+		// credentials were present, not that they were valid, so the only
+		// safe conditions for letting the request through are a resolved
+		// user or an explicit out-of-band grant (gl-inet).
+		if u == nil && !granted {
+			mw.denyUnauthenticated(w, r)
 
 			return
 		}
 
-		if err != nil {
-			mw.logger.ErrorContext(ctx, "retrieving user from request", slogutil.KeyError, err)
+		if u != nil {
+			if csrfErr := checkCSRF(r, method); csrfErr != nil {
+				mw.logger.DebugContext(ctx, "csrf check failed", slogutil.KeyError, csrfErr)
+				w.WriteHeader(http.StatusForbidden)
+
+				return
+			}
+
+			ctx = withWebUser(ctx, u)
 		}
 
-		w.WriteHeader(http.StatusUnauthorized)
+		h.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-// needsAuthentication returns true if the current request requires
-// authentication.
-//
-// TODO(s.chzhen):  Use the request's path.
-func (mw *authMiddlewareDefault) needsAuthentication(
-	ctx context.Context,
-	_ *http.Request,
-) (ok bool) {
-	users, err := mw.users.All(ctx)
-	if err != nil {
-		// Should not happen.
-		panic(err)
-	}
+// denyUnauthenticated responds to an unauthenticated request, redirecting
+// browser navigations to the login page and returning a plain 401 to API
+// clients.
+func (mw *authMiddlewareDefault) denyUnauthenticated(w http.ResponseWriter, r *http.Request) {
+	if strings.Contains(r.Header.Get(httphdr.Accept), "text/html") {
+		http.Redirect(w, r, "/login.html", http.StatusFound)
 
-	if len(users) == 0 {
-		return false
+		return
 	}
 
-	return true
+	w.WriteHeader(http.StatusUnauthorized)
 }
 
-// userFromRequest tries to retrieve a user based on the request.
-func (mw *authMiddlewareDefault) userFromRequest(
+// userFromRequestCookie resolves the user identified by the session cookie,
+// enforcing the configured absolute session lifetime.
+func (mw *authMiddlewareDefault) userFromRequestCookie(
 	ctx context.Context,
 	r *http.Request,
 ) (u *aghuser.User, err error) {
-	defer func() { err = errors.Annotate(err, "getting user from request: %w") }()
-
 	cookie, err := r.Cookie(sessionCookieName)
-	if err == http.ErrNoCookie {
-		return mw.userFromRequestBasicAuth(ctx, r)
+	if err != nil {
+		return nil, fmt.Errorf("getting cookie: %w", err)
 	}
 
 	sess, err := hex.DecodeString(cookie.Value)
@@ -479,6 +452,16 @@ func (mw *authMiddlewareDefault) userFromRequest(
 		return nil, nil
 	}
 
+	if time.Since(s.CreatedAt) > mw.maxSessionLifetime {
+		return nil, fmt.Errorf("session for user %q exceeded maximum lifetime", s.UserLogin)
+	}
+
+	if err = mw.sessions.Touch(ctx, t, time.Now().UTC()); err != nil {
+		// Non-fatal: failing to record the last-seen time shouldn't deny an
+		// otherwise valid session.
+		mw.logger.ErrorContext(ctx, "updating session last seen", slogutil.KeyError, err)
+	}
+
 	u, err = mw.users.ByLogin(ctx, s.UserLogin)
 	if err != nil {
 		return nil, fmt.Errorf("searching user by login %q: %w", s.UserLogin, err)
@@ -487,6 +470,27 @@ func (mw *authMiddlewareDefault) userFromRequest(
 	return u, nil
 }
 
+// needsAuthentication returns true if the current request requires
+// authentication.
+//
+// TODO(s.chzhen):  Use the request's path.
+func (mw *authMiddlewareDefault) needsAuthentication(
+	ctx context.Context,
+	_ *http.Request,
+) (ok bool) {
+	users, err := mw.users.All(ctx)
+	if err != nil {
+		// Should not happen.
+		panic(err)
+	}
+
+	if len(users) == 0 {
+		return false
+	}
+
+	return true
+}
+
 // userFromRequestBasicAuth searches for a user using Basic Auth credentials.
 func (mw *authMiddlewareDefault) userFromRequestBasicAuth(
 	ctx context.Context,
@@ -498,14 +502,21 @@ func (mw *authMiddlewareDefault) userFromRequestBasicAuth(
 	}
 
 	user, _ = mw.users.ByLogin(ctx, aghuser.Login(login))
-	if user == nil {
-		return nil, errInvalidLogin
+	if user != nil && user.Password.Authenticate(ctx, pass) {
+		return user, nil
 	}
 
-	ok = user.Password.Authenticate(ctx, pass)
-	if !ok {
-		return nil, errInvalidLogin
+	if mw.ldap != nil {
+		remoteIP, ipErr := netutil.SplitHost(r.RemoteAddr)
+		if ipErr != nil {
+			return nil, errInvalidLogin
+		}
+
+		user, err = mw.ldap.userFromRequestLDAP(ctx, login, pass, remoteIP)
+		if err == nil {
+			return user, nil
+		}
 	}
 
-	return user, nil
+	return nil, errInvalidLogin
 }
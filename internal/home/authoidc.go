@@ -0,0 +1,787 @@
+package home
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghuser"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+)
+
+// oidcStateCookieName is the name of the cookie that carries the OIDC state
+// nonce between the authorization request and the callback.
+const oidcStateCookieName = "agh_oidc_state"
+
+// oidcStateTTL is how long an outstanding OIDC state/PKCE pair is considered
+// valid.  Authorization flows that take longer than this are rejected.
+const oidcStateTTL = 10 * time.Minute
+
+// OIDCConfig is the configuration of the OpenID Connect single sign-on
+// backend.  A zero-value OIDCConfig is an inactive configuration.
+type OIDCConfig struct {
+	// Issuer is the base URL of the identity provider, e.g.
+	// "https://accounts.example.com".  It is used to discover the provider's
+	// authorization, token, and end-session endpoints.
+	Issuer string `yaml:"issuer" json:"issuer"`
+
+	// ClientID is the OAuth2 client identifier issued by the provider.
+	ClientID string `yaml:"client_id" json:"client_id"`
+
+	// ClientSecret is the OAuth2 client secret issued by the provider.  It is
+	// never rendered back to clients.
+	ClientSecret string `yaml:"client_secret" json:"-"`
+
+	// RedirectURL is the absolute URL of the callback handler, e.g.
+	// "https://agh.example.com/control/oidc/callback".
+	RedirectURL string `yaml:"redirect_url" json:"redirect_url"`
+
+	// Scopes are the OAuth2 scopes requested in addition to "openid".
+	Scopes []string `yaml:"scopes" json:"scopes"`
+
+	// UsernameClaim is the ID-token claim mapped to an [aghuser.Login].  If
+	// empty, "preferred_username" is used.
+	UsernameClaim string `yaml:"username_claim" json:"username_claim"`
+
+	// GroupsClaim is the ID-token claim containing the user's group
+	// memberships.  If it is empty while AllowedGroups is non-empty, every
+	// sign-in is rejected.
+	GroupsClaim string `yaml:"groups_claim" json:"groups_claim"`
+
+	// AllowedGroups, when non-empty, restricts sign-in to users whose
+	// GroupsClaim contains at least one of these values.
+	AllowedGroups []string `yaml:"allowed_groups" json:"allowed_groups"`
+
+	// AutoProvision, when true, creates a local [aghuser.User] record for
+	// identities seen for the first time.  When false, the user must already
+	// exist locally.
+	AutoProvision bool `yaml:"auto_provision" json:"auto_provision"`
+
+	// Enabled indicates whether the OIDC backend is active.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+}
+
+// oidcDiscovery is the subset of the OpenID Connect discovery document that
+// authMiddlewareOIDC relies on.
+type oidcDiscovery struct {
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcJWK is the subset of a JSON Web Key that authMiddlewareOIDC relies on
+// to verify RS256 ID-token signatures.
+type oidcJWK struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// oidcJWKS is a JSON Web Key Set, as served from [oidcDiscovery.JWKSURI].
+type oidcJWKS struct {
+	Keys []oidcJWK `json:"keys"`
+}
+
+// oidcPending is the server-side bookkeeping for a single in-flight
+// authorization-code flow.
+type oidcPending struct {
+	verifier  string
+	createdAt time.Time
+}
+
+// authMiddlewareOIDC is the OpenID Connect authentication backend.  It
+// implements the authorization-code flow with PKCE and maps the resulting ID
+// token to a local [aghuser.User].
+type authMiddlewareOIDC struct {
+	logger *slog.Logger
+	conf   *OIDCConfig
+	users  aghuser.DB
+	client *http.Client
+
+	mu      *sync.Mutex
+	pending map[string]*oidcPending
+	disco   *oidcDiscovery
+	jwks    *oidcJWKS
+}
+
+// newAuthMiddlewareOIDC returns a new properly initialized
+// *authMiddlewareOIDC, or nil if conf is nil or disabled.
+func newAuthMiddlewareOIDC(
+	logger *slog.Logger,
+	conf *OIDCConfig,
+	users aghuser.DB,
+) (mw *authMiddlewareOIDC) {
+	if conf == nil || !conf.Enabled {
+		return nil
+	}
+
+	return &authMiddlewareOIDC{
+		logger:  logger,
+		conf:    conf,
+		users:   users,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		mu:      &sync.Mutex{},
+		pending: map[string]*oidcPending{},
+	}
+}
+
+// discovery returns the cached discovery document, fetching it on first use.
+func (mw *authMiddlewareOIDC) discovery(ctx context.Context) (d *oidcDiscovery, err error) {
+	mw.mu.Lock()
+	d = mw.disco
+	mw.mu.Unlock()
+
+	if d != nil {
+		return d, nil
+	}
+
+	u := strings.TrimSuffix(mw.conf.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("building discovery request: %w", err)
+	}
+
+	resp, err := mw.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching discovery document: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	d = &oidcDiscovery{}
+	err = json.NewDecoder(resp.Body).Decode(d)
+	if err != nil {
+		return nil, fmt.Errorf("decoding discovery document: %w", err)
+	}
+
+	mw.mu.Lock()
+	mw.disco = d
+	mw.mu.Unlock()
+
+	return d, nil
+}
+
+// jwkSet returns the cached JSON Web Key Set, fetching it from the
+// discovery document's JWKSURI on first use.
+func (mw *authMiddlewareOIDC) jwkSet(ctx context.Context) (ks *oidcJWKS, err error) {
+	mw.mu.Lock()
+	ks = mw.jwks
+	mw.mu.Unlock()
+
+	if ks != nil {
+		return ks, nil
+	}
+
+	disco, err := mw.discovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if disco.JWKSURI == "" {
+		return nil, errors.Error("discovery document has no jwks_uri")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, disco.JWKSURI, nil)
+	if err != nil {
+		return nil, fmt.Errorf("building jwks request: %w", err)
+	}
+
+	resp, err := mw.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching jwks: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	ks = &oidcJWKS{}
+	err = json.NewDecoder(resp.Body).Decode(ks)
+	if err != nil {
+		return nil, fmt.Errorf("decoding jwks: %w", err)
+	}
+
+	mw.mu.Lock()
+	mw.jwks = ks
+	mw.mu.Unlock()
+
+	return ks, nil
+}
+
+// rsaPublicKeyFromJWK decodes the RSA public key encoded in k's "n" and "e"
+// members, per RFC 7518 §6.3.1.
+func rsaPublicKeyFromJWK(k oidcJWK) (pub *rsa.PublicKey, err error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("decoding modulus: %w", err)
+	}
+
+	eBytes, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("decoding exponent: %w", err)
+	}
+
+	e := new(big.Int).SetBytes(eBytes)
+	if !e.IsInt64() {
+		return nil, errors.Error("exponent out of range")
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(nBytes),
+		E: int(e.Int64()),
+	}, nil
+}
+
+// verifyJWS checks that sig is a valid RS256 signature of signingInput made
+// by the key identified by kid in ks.  RS256 is, at the time of writing, the
+// only algorithm AdGuard Home's supported identity providers (Keycloak,
+// Auth0, Google) issue ID tokens with by default.
+func verifyJWS(ks *oidcJWKS, kid string, alg string, signingInput string, sig []byte) (err error) {
+	if alg != "RS256" {
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+
+	for _, k := range ks.Keys {
+		if k.Kid != kid || (k.Kty != "" && k.Kty != "RSA") {
+			continue
+		}
+
+		pub, keyErr := rsaPublicKeyFromJWK(k)
+		if keyErr != nil {
+			return fmt.Errorf("decoding jwk %q: %w", kid, keyErr)
+		}
+
+		sum := sha256.Sum256([]byte(signingInput))
+
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig)
+	}
+
+	return fmt.Errorf("no matching jwk for kid %q", kid)
+}
+
+// oidcRandom returns a random URL-safe string of n raw bytes, suitable for
+// state nonces and PKCE verifiers.
+func oidcRandom(n int) (s string, err error) {
+	b := make([]byte, n)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("reading random bytes: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// pkceChallenge returns the S256 code challenge for the given verifier, per
+// RFC 7636.
+func pkceChallenge(verifier string) (challenge string) {
+	sum := sha256.Sum256([]byte(verifier))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// sweepPendingLocked removes entries from mw.pending older than oidcStateTTL.
+// A completed flow already removes its own entry in handleOIDCCallback, but
+// an authorization request that's never completed otherwise has nothing to
+// garbage-collect it, letting an unauthenticated client grow mw.pending
+// without bound by repeatedly hitting /control/oidc/login. mw.mu must be
+// held by the caller.
+func (mw *authMiddlewareOIDC) sweepPendingLocked() {
+	now := time.Now()
+	for state, pend := range mw.pending {
+		if now.Sub(pend.createdAt) > oidcStateTTL {
+			delete(mw.pending, state)
+		}
+	}
+}
+
+// handleOIDCLogin is the handler for the GET /control/oidc/login HTTP API.
+// It redirects the client to the identity provider's authorization endpoint.
+func (mw *authMiddlewareOIDC) handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	disco, err := mw.discovery(ctx)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadGateway, "oidc: discovery: %s", err)
+
+		return
+	}
+
+	state, err := oidcRandom(32)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "oidc: %s", err)
+
+		return
+	}
+
+	verifier, err := oidcRandom(32)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "oidc: %s", err)
+
+		return
+	}
+
+	mw.mu.Lock()
+	mw.sweepPendingLocked()
+	mw.pending[state] = &oidcPending{verifier: verifier, createdAt: time.Now()}
+	mw.mu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    state,
+		Path:     "/control/oidc",
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {mw.conf.ClientID},
+		"redirect_uri":          {mw.conf.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, mw.conf.Scopes...), " ")},
+		"state":                 {state},
+		"code_challenge":        {pkceChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+		"nonce":                 {state},
+	}
+
+	http.Redirect(w, r, disco.AuthorizationEndpoint+"?"+q.Encode(), http.StatusFound)
+}
+
+// handleOIDCCallback is the handler for the GET /control/oidc/callback HTTP
+// API.  It exchanges the authorization code, validates the ID token, and
+// issues the regular session cookie on success.
+func (mw *authMiddlewareOIDC) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// realIP cannot be used here without taking TrustedProxies into account
+	// due to security issues, same as handleLogin.
+	//
+	// See https://github.com/AdguardTeam/AdGuardHome/issues/2799.
+	remoteIP, err := netutil.SplitHost(r.RemoteAddr)
+	if err != nil {
+		writeErrorWithIP(r, w, http.StatusBadRequest, r.RemoteAddr, "oidc: getting remote address: %s", err)
+
+		return
+	}
+
+	rateLimiter := globalContext.auth.rateLimiter
+	if rateLimiter != nil {
+		if left := rateLimiter.check(remoteIP); left > 0 {
+			w.Header().Set(httphdr.RetryAfter, strconv.Itoa(int(left.Seconds())))
+			writeErrorWithIP(r, w, http.StatusTooManyRequests, remoteIP, "oidc: blocked for %s", left)
+
+			return
+		}
+	}
+
+	ip, err := realIP(r)
+	if err != nil {
+		log.Error("oidc: getting real ip from request with remote ip %s: %s", remoteIP, err)
+	}
+
+	logIP := remoteIP
+	if globalContext.auth.trustedProxies.Contains(ip.Unmap()) {
+		logIP = ip.String()
+	}
+
+	fail := func(status int, format string, args ...any) {
+		if rateLimiter != nil {
+			rateLimiter.inc(remoteIP)
+		}
+
+		writeErrorWithIP(r, w, status, logIP, format, args...)
+	}
+
+	q := r.URL.Query()
+	state := q.Get("state")
+	code := q.Get("code")
+
+	stateCookie, err := r.Cookie(oidcStateCookieName)
+	if err != nil || stateCookie.Value == "" ||
+		subtle.ConstantTimeCompare([]byte(stateCookie.Value), []byte(state)) != 1 {
+		fail(http.StatusForbidden, "oidc: state mismatch")
+
+		return
+	}
+
+	mw.mu.Lock()
+	pend, ok := mw.pending[state]
+	delete(mw.pending, state)
+	mw.mu.Unlock()
+
+	if !ok || time.Since(pend.createdAt) > oidcStateTTL {
+		fail(http.StatusForbidden, "oidc: unknown or expired state")
+
+		return
+	}
+
+	idToken, err := mw.exchangeCode(ctx, code, pend.verifier)
+	if err != nil {
+		fail(http.StatusForbidden, "oidc: exchanging code: %s", err)
+
+		return
+	}
+
+	claims, err := mw.verifyIDToken(ctx, idToken, state)
+	if err != nil {
+		fail(http.StatusForbidden, "oidc: validating id token: %s", err)
+
+		return
+	}
+
+	login, err := mw.loginFromClaims(claims)
+	if err != nil {
+		fail(http.StatusForbidden, "oidc: %s", err)
+
+		return
+	}
+
+	u, err := mw.users.ByLogin(ctx, login)
+	if err != nil {
+		fail(http.StatusInternalServerError, "oidc: looking up user: %s", err)
+
+		return
+	}
+
+	if u == nil {
+		if !mw.conf.AutoProvision {
+			fail(http.StatusForbidden, "oidc: user %q is not provisioned", login)
+
+			return
+		}
+
+		u, err = mw.provisionUser(ctx, login)
+		if err != nil {
+			fail(http.StatusInternalServerError, "oidc: provisioning user: %s", err)
+
+			return
+		}
+	}
+
+	if rateLimiter != nil {
+		rateLimiter.remove(remoteIP)
+	}
+
+	sess := newSessionToken()
+	now := time.Now().UTC()
+
+	globalContext.auth.addSession(sess, &session{
+		userName: u.Name,
+		expire:   uint32(now.Unix()) + globalContext.auth.sessionTTL,
+	})
+
+	tok := aghuser.SessionToken(sess)
+
+	err = globalContext.auth.sessions.Add(ctx, &aghuser.Session{
+		Token:     tok,
+		UserLogin: u.Login,
+		CreatedAt: now,
+		LastSeen:  now,
+		RemoteIP:  remoteIP,
+		UserAgent: r.UserAgent(),
+	})
+	if err != nil {
+		log.Error("oidc: recording session: %s", err)
+	} else {
+		evictOldestSessions(ctx, globalContext.auth.sessions, defaultMaxActiveSessionsPerUser, u.Login, tok)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    hex.EncodeToString(sess),
+		Path:     "/",
+		Expires:  now.Add(cookieTTL),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	// Issue a fresh CSRF token alongside the session cookie, same as
+	// handleLogin does for local sign-in.  handleLogin checks local accounts
+	// only; LDAP credentials are accepted through Basic Auth.
+	if _, csrfErr := setCSRFCookie(w, r); csrfErr != nil {
+		log.Error("oidc: issuing csrf token: %s", csrfErr)
+	}
+
+	log.Info("oidc: user %q successfully logged in via oidc from ip %s", login, logIP)
+
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// handleOIDCLogout is the handler for the GET /control/oidc/logout HTTP API.
+// It revokes the local session and, when the provider supports RP-initiated
+// logout, redirects to the provider's end-session endpoint.
+func (mw *authMiddlewareOIDC) handleOIDCLogout(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if c, err := r.Cookie(sessionCookieName); err == nil {
+		globalContext.auth.removeSession(c.Value)
+
+		if tok, hexErr := hex.DecodeString(c.Value); hexErr == nil {
+			if rmErr := globalContext.auth.sessions.Remove(ctx, aghuser.SessionToken(tok)); rmErr != nil {
+				log.Error("oidc: revoking session: %s", rmErr)
+			}
+		}
+	}
+
+	disco, err := mw.discovery(ctx)
+	if err != nil || disco.EndSessionEndpoint == "" {
+		http.Redirect(w, r, "/login.html", http.StatusFound)
+
+		return
+	}
+
+	// TODO(s.chzhen):  Pass id_token_hint once the ID token is persisted
+	// alongside the session; [aghuser.Session] doesn't carry it yet.
+	q := url.Values{"client_id": {mw.conf.ClientID}}
+
+	http.Redirect(w, r, disco.EndSessionEndpoint+"?"+q.Encode(), http.StatusFound)
+}
+
+// exchangeCode performs the authorization_code token exchange and returns the
+// raw, still-unverified ID token.
+func (mw *authMiddlewareOIDC) exchangeCode(
+	ctx context.Context,
+	code string,
+	verifier string,
+) (idToken string, err error) {
+	disco, err := mw.discovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {mw.conf.RedirectURL},
+		"client_id":     {mw.conf.ClientID},
+		"client_secret": {mw.conf.ClientSecret},
+		"code_verifier": {verifier},
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		disco.TokenEndpoint,
+		strings.NewReader(form.Encode()),
+	)
+	if err != nil {
+		return "", fmt.Errorf("building token request: %w", err)
+	}
+	req.Header.Set(httphdr.ContentType, "application/x-www-form-urlencoded")
+
+	resp, err := mw.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("performing token request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+		return "", fmt.Errorf("token endpoint returned %d: %s", resp.StatusCode, body)
+	}
+
+	var tokenResp struct {
+		IDToken string `json:"id_token"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&tokenResp)
+	if err != nil {
+		return "", fmt.Errorf("decoding token response: %w", err)
+	}
+
+	if tokenResp.IDToken == "" {
+		return "", errors.Error("token response did not contain an id_token")
+	}
+
+	return tokenResp.IDToken, nil
+}
+
+// verifyIDToken verifies idToken's JWS signature against the provider's JWKS
+// and validates its iss, aud, exp, and nonce claims, returning the decoded
+// claim set.  Claims are never trusted before the signature check succeeds.
+func (mw *authMiddlewareOIDC) verifyIDToken(
+	ctx context.Context,
+	idToken string,
+	wantNonce string,
+) (claims map[string]any, err error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.Error("malformed id token")
+	}
+
+	header := map[string]any{}
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("decoding id token header: %w", err)
+	}
+
+	if err = json.Unmarshal(headerBytes, &header); err != nil {
+		return nil, fmt.Errorf("unmarshaling id token header: %w", err)
+	}
+
+	alg, _ := header["alg"].(string)
+	kid, _ := header["kid"].(string)
+	if alg == "" || strings.EqualFold(alg, "none") {
+		return nil, fmt.Errorf("unacceptable signing algorithm %q", alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("decoding id token signature: %w", err)
+	}
+
+	ks, err := mw.jwkSet(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetching jwks: %w", err)
+	}
+
+	if err = verifyJWS(ks, kid, alg, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, fmt.Errorf("verifying signature: %w", err)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("decoding id token payload: %w", err)
+	}
+
+	claims = map[string]any{}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("unmarshaling id token claims: %w", err)
+	}
+
+	iss, _ := claims["iss"].(string)
+	if strings.TrimSuffix(iss, "/") != strings.TrimSuffix(mw.conf.Issuer, "/") {
+		return nil, fmt.Errorf("unexpected issuer %q", iss)
+	}
+
+	if !oidcAudienceContains(claims["aud"], mw.conf.ClientID) {
+		return nil, fmt.Errorf("unexpected audience %v", claims["aud"])
+	}
+
+	exp, _ := claims["exp"].(float64)
+	if time.Now().After(time.Unix(int64(exp), 0)) {
+		return nil, errors.Error("id token has expired")
+	}
+
+	nonce, _ := claims["nonce"].(string)
+	if subtle.ConstantTimeCompare([]byte(nonce), []byte(wantNonce)) != 1 {
+		return nil, errors.Error("nonce mismatch")
+	}
+
+	return claims, nil
+}
+
+// oidcAudienceContains returns true if aud, which may be a string or a slice
+// of strings per the OIDC spec, contains clientID.
+func oidcAudienceContains(aud any, clientID string) (ok bool) {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []any:
+		for _, a := range v {
+			if s, isStr := a.(string); isStr && s == clientID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// loginFromClaims maps the configured username and groups claims to an
+// [aghuser.Login], enforcing AllowedGroups when configured.
+func (mw *authMiddlewareOIDC) loginFromClaims(claims map[string]any) (login aghuser.Login, err error) {
+	claimName := mw.conf.UsernameClaim
+	if claimName == "" {
+		claimName = "preferred_username"
+	}
+
+	name, _ := claims[claimName].(string)
+	if name == "" {
+		return "", fmt.Errorf("claim %q is missing or empty", claimName)
+	}
+
+	if len(mw.conf.AllowedGroups) > 0 {
+		if mw.conf.GroupsClaim == "" || !oidcHasAllowedGroup(claims[mw.conf.GroupsClaim], mw.conf.AllowedGroups) {
+			return "", fmt.Errorf("user %q is not a member of an allowed group", name)
+		}
+	}
+
+	return aghuser.Login(name), nil
+}
+
+// oidcHasAllowedGroup returns true if groups, a JSON array claim value,
+// contains at least one of allowed.
+func oidcHasAllowedGroup(groups any, allowed []string) (ok bool) {
+	list, isSlice := groups.([]any)
+	if !isSlice {
+		return false
+	}
+
+	for _, g := range list {
+		s, isStr := g.(string)
+		if !isStr {
+			continue
+		}
+
+		for _, a := range allowed {
+			if s == a {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// provisionUser creates a local, passwordless user record for an identity
+// authenticated by the OIDC provider.
+func (mw *authMiddlewareOIDC) provisionUser(
+	ctx context.Context,
+	login aghuser.Login,
+) (u *aghuser.User, err error) {
+	u = &aghuser.User{
+		Login: login,
+		Name:  string(login),
+	}
+
+	err = mw.users.Create(ctx, u)
+	if err != nil {
+		return nil, fmt.Errorf("creating user %q: %w", login, err)
+	}
+
+	return u, nil
+}
+
+// registerOIDCHandlers registers the OIDC routes if mw is configured.
+func registerOIDCHandlers(mw *authMiddlewareOIDC) {
+	if mw == nil {
+		return
+	}
+
+	httpRegister(http.MethodGet, "/control/oidc/login", mw.handleOIDCLogin)
+	httpRegister(http.MethodGet, "/control/oidc/callback", mw.handleOIDCCallback)
+	httpRegister(http.MethodGet, "/control/oidc/logout", mw.handleOIDCLogout)
+}
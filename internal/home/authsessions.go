@@ -0,0 +1,150 @@
+package home
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghuser"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// sessionJSON is the JSON representation of a single web user session, as
+// returned by the GET /control/sessions HTTP API.
+type sessionJSON struct {
+	Token     string `json:"token"`
+	CreatedAt int64  `json:"created_at"`
+	LastSeen  int64  `json:"last_seen"`
+	RemoteIP  string `json:"remote_ip"`
+	UserAgent string `json:"user_agent"`
+	Current   bool   `json:"current"`
+}
+
+// evictOldestSessions removes the oldest sessions belonging to login in
+// sessions beyond maxSessions, keeping keep as the session that must never be
+// evicted as part of its own creation.  It is a free function, rather than a
+// method on [authMiddlewareDefault], because the actual session-creation path
+// ([Auth.newCookie]) predates that middleware and doesn't hold a reference to
+// it.
+func evictOldestSessions(
+	ctx context.Context,
+	sessions aghuser.SessionStorage,
+	maxSessions int,
+	login aghuser.Login,
+	keep aghuser.SessionToken,
+) {
+	list, err := sessions.ListByUser(ctx, login)
+	if err != nil {
+		log.Error("auth: listing sessions for eviction: %s", err)
+
+		return
+	}
+
+	if len(list) <= maxSessions {
+		return
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+
+	for _, s := range list[maxSessions:] {
+		if string(s.Token) == string(keep) {
+			continue
+		}
+
+		if err = sessions.Remove(ctx, s.Token); err != nil {
+			log.Error("auth: evicting session: %s", err)
+		}
+	}
+}
+
+// handleGetSessions is the handler for the GET /control/sessions HTTP API.
+// It returns the active sessions belonging to the current user.
+func handleGetSessions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	u := webUserFromContext(ctx)
+	if u == nil {
+		aghhttp.Error(r, w, http.StatusUnauthorized, "not authenticated")
+
+		return
+	}
+
+	sessions, err := globalContext.auth.sessions.ListByUser(ctx, u.Login)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "listing sessions: %s", err)
+
+		return
+	}
+
+	var currentToken string
+	if cookie, cerr := r.Cookie(sessionCookieName); cerr == nil {
+		currentToken = cookie.Value
+	}
+
+	resp := make([]sessionJSON, 0, len(sessions))
+	for _, s := range sessions {
+		tok := hex.EncodeToString(s.Token)
+		resp = append(resp, sessionJSON{
+			Token:     tok,
+			CreatedAt: s.CreatedAt.Unix(),
+			LastSeen:  s.LastSeen.Unix(),
+			RemoteIP:  s.RemoteIP,
+			UserAgent: s.UserAgent,
+			Current:   tok == currentToken,
+		})
+	}
+
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// handleDeleteSession is the handler for the DELETE /control/sessions/{token}
+// HTTP API.  It revokes a session belonging to the current user.
+func handleDeleteSession(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	u := webUserFromContext(ctx)
+	if u == nil {
+		aghhttp.Error(r, w, http.StatusUnauthorized, "not authenticated")
+
+		return
+	}
+
+	tokenHex := r.PathValue("token")
+	tok, err := hex.DecodeString(tokenHex)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding token: %s", err)
+
+		return
+	}
+
+	s, err := globalContext.auth.sessions.FindByToken(ctx, aghuser.SessionToken(tok))
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "finding session: %s", err)
+
+		return
+	}
+
+	if s == nil || s.UserLogin != u.Login {
+		aghhttp.Error(r, w, http.StatusNotFound, "session not found")
+
+		return
+	}
+
+	err = globalContext.auth.sessions.Remove(ctx, aghuser.SessionToken(tok))
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "revoking session: %s", err)
+
+		return
+	}
+
+	aghhttp.OK(w)
+}
+
+// registerSessionHandlers registers the session-management routes.
+func registerSessionHandlers() {
+	httpRegister(http.MethodGet, "/control/sessions", handleGetSessions)
+	httpRegister(http.MethodDelete, "/control/sessions/{token}", handleDeleteSession)
+}
@@ -0,0 +1,160 @@
+package home
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghuser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSessionStorage is an in-memory [aghuser.SessionStorage] used to test
+// session bookkeeping logic without a real storage backend.
+type fakeSessionStorage struct {
+	mu       sync.Mutex
+	sessions map[string]*aghuser.Session
+}
+
+// newFakeSessionStorage returns a new, empty *fakeSessionStorage.
+func newFakeSessionStorage() (s *fakeSessionStorage) {
+	return &fakeSessionStorage{sessions: map[string]*aghuser.Session{}}
+}
+
+// type check
+var _ aghuser.SessionStorage = (*fakeSessionStorage)(nil)
+
+// Add implements the [aghuser.SessionStorage] interface for
+// *fakeSessionStorage.
+func (s *fakeSessionStorage) Add(_ context.Context, sess *aghuser.Session) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[string(sess.Token)] = sess
+
+	return nil
+}
+
+// Remove implements the [aghuser.SessionStorage] interface for
+// *fakeSessionStorage.
+func (s *fakeSessionStorage) Remove(_ context.Context, tok aghuser.SessionToken) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, string(tok))
+
+	return nil
+}
+
+// FindByToken implements the [aghuser.SessionStorage] interface for
+// *fakeSessionStorage.
+func (s *fakeSessionStorage) FindByToken(
+	_ context.Context,
+	tok aghuser.SessionToken,
+) (sess *aghuser.Session, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sessions[string(tok)], nil
+}
+
+// Touch implements the [aghuser.SessionStorage] interface for
+// *fakeSessionStorage.
+func (s *fakeSessionStorage) Touch(
+	_ context.Context,
+	tok aghuser.SessionToken,
+	lastSeen time.Time,
+) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sess, ok := s.sessions[string(tok)]; ok {
+		sess.LastSeen = lastSeen
+	}
+
+	return nil
+}
+
+// ListByUser implements the [aghuser.SessionStorage] interface for
+// *fakeSessionStorage.
+func (s *fakeSessionStorage) ListByUser(
+	_ context.Context,
+	login aghuser.Login,
+) (sessions []*aghuser.Session, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sess := range s.sessions {
+		if sess.UserLogin == login {
+			sessions = append(sessions, sess)
+		}
+	}
+
+	return sessions, nil
+}
+
+func TestEvictOldestSessions(t *testing.T) {
+	const login aghuser.Login = "user"
+
+	newSessions := func(n int) (storage *fakeSessionStorage, tokens []aghuser.SessionToken) {
+		storage = newFakeSessionStorage()
+		base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		for i := range n {
+			tok := aghuser.SessionToken([]byte{byte(i)})
+			tokens = append(tokens, tok)
+
+			err := storage.Add(context.Background(), &aghuser.Session{
+				Token:     tok,
+				UserLogin: login,
+				// Each session is newer than the last, so tokens[0] is the
+				// oldest and the last element is the newest.
+				CreatedAt: base.Add(time.Duration(i) * time.Hour),
+				LastSeen:  base.Add(time.Duration(i) * time.Hour),
+			})
+			require.NoError(t, err)
+		}
+
+		return storage, tokens
+	}
+
+	t.Run("under_cap", func(t *testing.T) {
+		storage, tokens := newSessions(2)
+
+		evictOldestSessions(context.Background(), storage, 5, login, tokens[len(tokens)-1])
+
+		assert.Len(t, storage.sessions, 2)
+	})
+
+	t.Run("over_cap", func(t *testing.T) {
+		storage, tokens := newSessions(5)
+
+		evictOldestSessions(context.Background(), storage, 2, login, tokens[len(tokens)-1])
+
+		assert.Len(t, storage.sessions, 2)
+
+		// The two newest sessions must survive; the three oldest must be
+		// gone.
+		for _, tok := range tokens[3:] {
+			_, ok := storage.sessions[string(tok)]
+			assert.True(t, ok)
+		}
+
+		for _, tok := range tokens[:3] {
+			_, ok := storage.sessions[string(tok)]
+			assert.False(t, ok)
+		}
+	})
+
+	t.Run("keep_survives_even_if_oldest", func(t *testing.T) {
+		storage, tokens := newSessions(3)
+		oldest := tokens[0]
+
+		evictOldestSessions(context.Background(), storage, 1, login, oldest)
+
+		_, ok := storage.sessions[string(oldest)]
+		assert.True(t, ok, "the session passed as keep must never be evicted")
+	})
+}
@@ -0,0 +1,121 @@
+package home
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+)
+
+// csrfCookieName is the name of the non-HttpOnly cookie that carries the
+// double-submit CSRF token.
+const csrfCookieName = "agh_csrf"
+
+// csrfHeaderName is the header the frontend must echo the token back in for
+// state-changing requests.
+const csrfHeaderName = "X-CSRF-Token"
+
+// csrfTokenLength is the number of random bytes used for a CSRF token.
+const csrfTokenLength = 32
+
+// newCSRFToken returns a new random, hex-encoded CSRF token.
+func newCSRFToken() (token string, err error) {
+	b := make([]byte, csrfTokenLength)
+	if _, err = rand.Read(b); err != nil {
+		return "", fmt.Errorf("reading random bytes: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// setCSRFCookie issues a new CSRF token and sets it as a non-HttpOnly cookie
+// so that the frontend's JavaScript can read it back into the
+// [csrfHeaderName] header.  It is currently called on login ([handleLogin],
+// [authMiddlewareOIDC.handleOIDCCallback]) and from the bootstrap endpoint
+// ([handleGetCSRF]).
+//
+// TODO(s.chzhen):  Also rotate the token on other privilege-changing actions,
+// such as a password change, once those call sites exist.
+func setCSRFCookie(w http.ResponseWriter, r *http.Request) (token string, err error) {
+	token, err = newCSRFToken()
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: false,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return token, nil
+}
+
+// isCSRFExempt returns true if the HTTP method doesn't mutate state and
+// therefore doesn't require a CSRF check.
+func isCSRFExempt(method string) (ok bool) {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// checkCSRF validates the double-submit CSRF token for a cookie-authenticated
+// state-changing request.  It returns nil if r is exempt, e.g. because it
+// isn't cookie-authenticated or doesn't mutate state.  method must be the
+// [AuthMethod] that [AuthChain.Authenticate] actually used to authenticate r,
+// not one re-derived from r's headers: a request can carry a stray or
+// cached Basic-Auth header (embedded webviews are known to auto-replay
+// these) alongside a valid session cookie, and [AuthChain] tries providers in
+// a fixed order, so only the method that won the chain may be trusted to
+// decide exemption.
+func checkCSRF(r *http.Request, method AuthMethod) (err error) {
+	if isCSRFExempt(r.Method) {
+		return nil
+	}
+
+	// Bearer/API-key and Basic Auth credentials are sent explicitly by the
+	// caller on every request, so they aren't subject to ambient authority
+	// and don't need CSRF protection.
+	if method != AuthMethodCookie {
+		return nil
+	}
+
+	cookie, err := r.Cookie(csrfCookieName)
+	if err != nil {
+		return fmt.Errorf("missing %s cookie", csrfCookieName)
+	}
+
+	header := r.Header.Get(csrfHeaderName)
+	if header == "" {
+		return fmt.Errorf("missing %s header", csrfHeaderName)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) != 1 {
+		return fmt.Errorf("%s header does not match %s cookie", csrfHeaderName, csrfCookieName)
+	}
+
+	return nil
+}
+
+// handleGetCSRF is the handler for the GET /control/csrf HTTP API.  It lets
+// the frontend bootstrap a CSRF token after a page reload, when the cookie
+// set at login may no longer be accessible to the running script.
+func handleGetCSRF(w http.ResponseWriter, r *http.Request) {
+	token, err := setCSRFCookie(w, r)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "issuing csrf token: %s", err)
+
+		return
+	}
+
+	_, _ = w.Write([]byte(token))
+}
+
+// registerCSRFHandlers registers the CSRF bootstrap route.
+func registerCSRFHandlers() {
+	httpRegister(http.MethodGet, "/control/csrf", handleGetCSRF)
+}
@@ -0,0 +1,117 @@
+package home
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckCSRF(t *testing.T) {
+	const matchingToken = "token-value"
+
+	newRequest := func(method string, cookie, header string) (r *http.Request) {
+		r = httptest.NewRequest(method, "/control/stats_reset", nil)
+		if cookie != "" {
+			r.AddCookie(&http.Cookie{Name: csrfCookieName, Value: cookie})
+		}
+
+		if header != "" {
+			r.Header.Set(csrfHeaderName, header)
+		}
+
+		return r
+	}
+
+	testCases := []struct {
+		name    string
+		method  string
+		authM   AuthMethod
+		cookie  string
+		header  string
+		wantErr bool
+	}{{
+		name:    "get_is_exempt",
+		method:  http.MethodGet,
+		authM:   AuthMethodCookie,
+		cookie:  "",
+		header:  "",
+		wantErr: false,
+	}, {
+		name:    "head_is_exempt",
+		method:  http.MethodHead,
+		authM:   AuthMethodCookie,
+		cookie:  "",
+		header:  "",
+		wantErr: false,
+	}, {
+		name:    "options_is_exempt",
+		method:  http.MethodOptions,
+		authM:   AuthMethodCookie,
+		cookie:  "",
+		header:  "",
+		wantErr: false,
+	}, {
+		name:    "api_key_is_exempt",
+		method:  http.MethodPost,
+		authM:   AuthMethodAPIKey,
+		cookie:  "",
+		header:  "",
+		wantErr: false,
+	}, {
+		name:    "basic_auth_is_exempt",
+		method:  http.MethodPost,
+		authM:   AuthMethodBasicAuth,
+		cookie:  "",
+		header:  "",
+		wantErr: false,
+	}, {
+		name:    "glinet_is_exempt",
+		method:  http.MethodPost,
+		authM:   AuthMethodGLiNet,
+		cookie:  "",
+		header:  "",
+		wantErr: false,
+	}, {
+		name:    "cookie_missing_csrf_cookie",
+		method:  http.MethodPost,
+		authM:   AuthMethodCookie,
+		cookie:  "",
+		header:  matchingToken,
+		wantErr: true,
+	}, {
+		name:    "cookie_missing_header",
+		method:  http.MethodPost,
+		authM:   AuthMethodCookie,
+		cookie:  matchingToken,
+		header:  "",
+		wantErr: true,
+	}, {
+		name:    "cookie_header_mismatch",
+		method:  http.MethodPost,
+		authM:   AuthMethodCookie,
+		cookie:  matchingToken,
+		header:  "other-value",
+		wantErr: true,
+	}, {
+		name:    "cookie_header_match",
+		method:  http.MethodPost,
+		authM:   AuthMethodCookie,
+		cookie:  matchingToken,
+		header:  matchingToken,
+		wantErr: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newRequest(tc.method, tc.cookie, tc.header)
+			err := checkCSRF(r, tc.authM)
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}